@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// commitUpdates applies goodUpdates and commits them, following
+// r.CommitMode and r.DoPR:
+//
+//   - r.DoPR: each update gets its own branch (jsupdate/<pkg>-<version>,
+//     or jsupdate/<group>-group for all members of a policy Group together)
+//     based off the branch jsupdate was run on, committed and pushed
+//     individually, with a pull request opened via the GitHub API if
+//     GITHUB_TOKEN is set.
+//   - r.CommitMode == "per-package" (and not DoPR): updates are applied,
+//     tested and committed one at a time (a policy Group as one unit),
+//     stacked on the current branch.
+//   - otherwise: a single commit covering every update, as before.
+func (r Runner) commitUpdates(goodUpdates []Update) error {
+	if len(goodUpdates) == 0 {
+		return nil
+	}
+	if r.DoPR {
+		return r.openPullRequests(goodUpdates)
+	}
+	if r.CommitMode == "per-package" {
+		return r.commitPerPackage(goodUpdates)
+	}
+	return r.commitAll(goodUpdates)
+}
+
+// commitAll commits every update in goodUpdates as a single commit.
+func (r Runner) commitAll(goodUpdates []Update) error {
+	message := []string{"Update package.json", ""}
+	for _, req := range goodUpdates {
+		message = append(message, fmt.Sprintf("* upgrade %s from %s to %s",
+			req.Package, req.Current, chosenVersion(req)))
+	}
+	return gitCommitAll(r.RootDir, strings.Join(message, "\n"))
+}
+
+// commitPerPackage applies goodUpdates one group at a time, stacking one
+// commit per unit on the current branch, so a reviewer can revert or
+// cherry-pick individual upgrades independently. A unit is either a single
+// ungrouped update or every member of a policy Group (see chunk0-7):
+// grouped packages only ever pass bisection together, so applying one in
+// isolation here would fail the same way.
+func (r Runner) commitPerPackage(goodUpdates []Update) error {
+	var applied []Update
+	for _, unit := range groupUnits(goodUpdates) {
+		applied = append(applied, unit...)
+
+		mod, err := r.Ecosystem.PinVersions(r.OriginalManifest, applied)
+		if err != nil {
+			return err
+		}
+		if err := r.Ecosystem.WriteManifest(r.RootDir, mod); err != nil {
+			return err
+		}
+		if err := r.Ecosystem.Install(r.RootDir, r.Env); err != nil {
+			return err
+		}
+		ok, err := r.test()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("update %s regressed when applied on top of earlier per-package commits", unitLabel(unit))
+		}
+
+		if err := gitCommitAll(r.RootDir, r.changelogCommitMessage(unit)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// openPullRequests gives each unit in goodUpdates its own branch, based off
+// the branch jsupdate was run on, applies and tests just that unit, commits,
+// pushes the branch, and opens a pull request via the GitHub API if
+// GITHUB_TOKEN is set. The working tree is left on the base branch once all
+// units have been processed. A unit is either a single ungrouped update or
+// every member of a policy Group (see chunk0-7), which can only ever pass
+// bisection as a whole.
+func (r Runner) openPullRequests(goodUpdates []Update) error {
+	baseBranch, err := gitCurrentBranch(r.RootDir)
+	if err != nil {
+		return err
+	}
+
+	token := os.Getenv("GITHUB_TOKEN")
+
+	for _, unit := range groupUnits(goodUpdates) {
+		branch := prBranchName(unit)
+		if err := gitCreateBranch(r.RootDir, branch, baseBranch); err != nil {
+			return err
+		}
+
+		mod, err := r.Ecosystem.PinVersions(r.OriginalManifest, unit)
+		if err != nil {
+			return err
+		}
+		if err := r.Ecosystem.WriteManifest(r.RootDir, mod); err != nil {
+			return err
+		}
+		if err := r.Ecosystem.Install(r.RootDir, r.Env); err != nil {
+			return err
+		}
+		ok, err := r.test()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("update %s failed in isolation on branch %s", unitLabel(unit), branch)
+		}
+
+		message := r.changelogCommitMessage(unit)
+		if err := gitCommitAll(r.RootDir, message); err != nil {
+			return err
+		}
+		if err := gitPush(r.RootDir, branch); err != nil {
+			return err
+		}
+
+		if token != "" {
+			if err := createGitHubPR(r.RootDir, token, branch, baseBranch, unit, message); err != nil {
+				return err
+			}
+		}
+
+		if err := gitCheckoutBranch(r.RootDir, baseBranch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// groupUnits splits updates into the atomic units commitPerPackage and
+// openPullRequests apply one at a time: every update sharing a non-empty
+// Group is collected into a single unit (in order of first appearance), and
+// each ungrouped update is its own unit.
+func groupUnits(updates []Update) [][]Update {
+	var units [][]Update
+	indexOfGroup := map[string]int{}
+	for _, u := range updates {
+		if u.Group != "" {
+			if i, ok := indexOfGroup[u.Group]; ok {
+				units[i] = append(units[i], u)
+				continue
+			}
+			indexOfGroup[u.Group] = len(units)
+		}
+		units = append(units, []Update{u})
+	}
+	return units
+}
+
+// unitLabel describes a unit for error messages: the package name for a
+// single-update unit, or the group name plus its members for a grouped one.
+func unitLabel(unit []Update) string {
+	if len(unit) == 1 {
+		return unit[0].Package
+	}
+	names := make([]string, len(unit))
+	for i, u := range unit {
+		names[i] = u.Package
+	}
+	return fmt.Sprintf("%s group (%s)", unit[0].Group, strings.Join(names, ", "))
+}
+
+// prBranchName returns the branch name openPullRequests uses for unit.
+func prBranchName(unit []Update) string {
+	if len(unit) == 1 {
+		return fmt.Sprintf("jsupdate/%s-%s", sanitizeBranchComponent(unit[0].Package), sanitizeBranchComponent(chosenVersion(unit[0])))
+	}
+	return fmt.Sprintf("jsupdate/%s-group", sanitizeBranchComponent(unit[0].Group))
+}
+
+// changelogCommitMessage builds the commit message for a unit (a single
+// package, or every member of a Group applied together), including a
+// changelog link per package when the ecosystem can find one.
+func (r Runner) changelogCommitMessage(unit []Update) string {
+	if len(unit) == 1 {
+		req := unit[0]
+		lines := []string{fmt.Sprintf("Update %s from %s to %s", req.Package, req.Current, chosenVersion(req))}
+		if cl, ok := r.Ecosystem.(changelogFinder); ok {
+			if url, err := cl.ChangelogURL(r.RootDir, req.Package); err == nil && url != "" {
+				lines = append(lines, "", url)
+			}
+		}
+		return strings.Join(lines, "\n")
+	}
+
+	lines := []string{fmt.Sprintf("Update %s group", unit[0].Group), ""}
+	for _, req := range unit {
+		lines = append(lines, fmt.Sprintf("* %s from %s to %s", req.Package, req.Current, chosenVersion(req)))
+	}
+	if cl, ok := r.Ecosystem.(changelogFinder); ok {
+		var links []string
+		for _, req := range unit {
+			if url, err := cl.ChangelogURL(r.RootDir, req.Package); err == nil && url != "" {
+				links = append(links, fmt.Sprintf("%s: %s", req.Package, url))
+			}
+		}
+		if len(links) > 0 {
+			lines = append(lines, "")
+			lines = append(lines, links...)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// chosenVersion returns the version an update was actually pinned to:
+// req.Chosen if bisection narrowed it, otherwise req.Latest.
+func chosenVersion(req Update) string {
+	if req.Chosen != "" {
+		return req.Chosen
+	}
+	return req.Latest
+}
+
+func gitCurrentBranch(rootDir string) (string, error) {
+	cmd := exec.Command("git", "-C", rootDir, "rev-parse", "--abbrev-ref", "HEAD")
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git rev-parse --abbrev-ref HEAD: %v", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func gitCreateBranch(rootDir, branch, base string) error {
+	cmd := exec.Command("git", "-C", rootDir, "checkout", "-b", branch, base)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+func gitCheckoutBranch(rootDir, branch string) error {
+	cmd := exec.Command("git", "-C", rootDir, "checkout", branch)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+func gitCommitAll(rootDir, message string) error {
+	add := exec.Command("git", "-C", rootDir, "add", "-A")
+	add.Stdout = os.Stdout
+	add.Stderr = os.Stderr
+	if err := add.Run(); err != nil {
+		return fmt.Errorf("git add failed: %v", err)
+	}
+	commit := exec.Command("git", "-C", rootDir, "commit", "-m", message)
+	commit.Stdout = os.Stdout
+	commit.Stderr = os.Stderr
+	if err := commit.Run(); err != nil {
+		return fmt.Errorf("git commit failed: %v", err)
+	}
+	return nil
+}
+
+func gitPush(rootDir, branch string) error {
+	cmd := exec.Command("git", "-C", rootDir, "push", "origin", branch)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// sanitizeBranchComponent makes a package name or version safe to use as
+// part of a git branch name.
+func sanitizeBranchComponent(s string) string {
+	s = strings.ReplaceAll(s, "/", "-")
+	s = strings.ReplaceAll(s, "@", "")
+	return s
+}
+
+// githubRepoSlug extracts "owner/repo" from the origin remote's URL.
+func githubRepoSlug(rootDir string) (string, error) {
+	cmd := exec.Command("git", "-C", rootDir, "remote", "get-url", "origin")
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git remote get-url origin: %v", err)
+	}
+
+	url := strings.TrimSpace(string(out))
+	url = strings.TrimSuffix(url, ".git")
+	url = strings.TrimPrefix(url, "git@github.com:")
+	if i := strings.Index(url, "github.com/"); i != -1 {
+		url = url[i+len("github.com/"):]
+	}
+	return url, nil
+}
+
+// createGitHubPR opens a pull request for branch against base using the
+// GitHub REST API.
+func createGitHubPR(rootDir, token, branch, base string, unit []Update, body string) error {
+	slug, err := githubRepoSlug(rootDir)
+	if err != nil {
+		return err
+	}
+
+	title := fmt.Sprintf("Update %s to %s", unit[0].Package, chosenVersion(unit[0]))
+	if len(unit) > 1 {
+		title = fmt.Sprintf("Update %s group", unit[0].Group)
+	}
+
+	payload, err := json.Marshal(map[string]string{
+		"title": title,
+		"head":  branch,
+		"base":  base,
+		"body":  body,
+	})
+	if err != nil {
+		return err
+	}
+
+	httpReq, err := http.NewRequest(http.MethodPost, fmt.Sprintf("https://api.github.com/repos/%s/pulls", slug), bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+token)
+	httpReq.Header.Set("Accept", "application/vnd.github+json")
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("creating pull request for %s: %v", unitLabel(unit), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("creating pull request for %s: unexpected status %s", unitLabel(unit), resp.Status)
+	}
+	return nil
+}
+
+// changelogFinder is implemented by ecosystems that can locate a changelog
+// link for a given package, for inclusion in per-package commit messages.
+type changelogFinder interface {
+	ChangelogURL(rootDir, pkg string) (string, error)
+}
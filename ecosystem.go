@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Ecosystem abstracts over the package manager and manifest format being
+// updated (npm/yarn's package.json, go.mod for Go modules, ...) so that the
+// bisection algorithm in Runner.try does not need to know which one it is
+// driving.
+type Ecosystem interface {
+	// Install installs dependencies as currently declared in the manifest.
+	// env, if non-empty, is appended to the subprocess environment (e.g. to
+	// point a concurrent install at its own cache directory).
+	Install(rootDir string, env []string) error
+	// Outdated returns the packages/modules that have available updates.
+	Outdated(rootDir string) ([]Update, error)
+	// ReadManifest reads the dependency manifest.
+	ReadManifest(rootDir string) (Manifest, error)
+	// WriteManifest writes the dependency manifest, plus any follow-up
+	// bookkeeping the ecosystem requires (e.g. `go mod tidy`). It should
+	// wrap the returned error with errUnresolvable if the failure means the
+	// given requirements can't be resolved together, as opposed to some
+	// other (e.g. I/O) failure.
+	WriteManifest(rootDir string, mf Manifest) error
+	// PinVersions returns a copy of mf with the versions in updates applied.
+	PinVersions(mf Manifest, updates []Update) (Manifest, error)
+}
+
+// Manifest is an opaque dependency manifest (package.json, go.mod, ...)
+// produced and consumed only by the Ecosystem implementation that created
+// it.
+type Manifest interface{}
+
+// errUnresolvable is wrapped into the error an Ecosystem.WriteManifest
+// returns when the manifest it was given can't be resolved (e.g. `go mod
+// tidy` couldn't find a consistent set of module versions). Runner.try
+// treats this the same as a failing test — proof that the current set of
+// updates is bad — rather than aborting the whole run.
+var errUnresolvable = errors.New("dependencies could not be resolved")
+
+// versionBisector is implemented by ecosystems that can search for an
+// intermediate version between Current and Latest when a straight upgrade
+// to Latest fails the tests. Ecosystems that can't (e.g. Go modules, where
+// `go list -m -u` only ever reports the latest version) are skipped, and
+// Runner.try simply drops the package.
+type versionBisector interface {
+	BisectVersion(rootDir string, mf Manifest, update Update, env []string, test func() (bool, error), indent string) (Update, error)
+}
+
+// vulnerabilityScanner is implemented by ecosystems that can report which
+// packages have known vulnerabilities, for the -security-only policy.
+// Ecosystems that can't (e.g. Go modules, which has no equivalent of `npm
+// audit` here) are skipped, and applyPolicy leaves security-only filtering
+// disabled rather than failing the run.
+type vulnerabilityScanner interface {
+	VulnerablePackages(rootDir string) (map[string]bool, error)
+}
+
+// detectEcosystem picks the Ecosystem to drive. If forced is non-empty it
+// names the ecosystem explicitly ("npm" or "go"); otherwise it is inferred
+// from the files present in rootDir.
+func detectEcosystem(rootDir, forced string) (Ecosystem, error) {
+	switch forced {
+	case "npm":
+		return npmEcosystem{}, nil
+	case "go":
+		return goEcosystem{}, nil
+	case "":
+		// fall through to auto-detection below
+	default:
+		return nil, fmt.Errorf("unknown -ecosystem %q", forced)
+	}
+
+	if _, err := os.Stat(filepath.Join(rootDir, "go.mod")); err == nil {
+		return goEcosystem{}, nil
+	}
+	if _, err := os.Stat(filepath.Join(rootDir, "package.json")); err == nil {
+		return npmEcosystem{}, nil
+	}
+	return nil, fmt.Errorf("could not detect ecosystem in %s: no package.json or go.mod found", rootDir)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"golang.org/x/mod/modfile"
+)
+
+// goEcosystem drives updates for Go modules via go.mod, using `go list -m -u
+// -json all` to discover available updates and golang.org/x/mod/modfile to
+// rewrite go.mod without disturbing unrelated formatting.
+type goEcosystem struct{}
+
+func (goEcosystem) Install(rootDir string, env []string) error {
+	cmd := exec.Command("go", "mod", "download")
+	cmd.Dir = rootDir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	return cmd.Run()
+}
+
+// goModule mirrors the subset of `go list -m -u -json all` output we care
+// about.
+type goModule struct {
+	Path     string
+	Version  string
+	Main     bool
+	Indirect bool
+	Update   *struct {
+		Version string
+	}
+}
+
+func (goEcosystem) Outdated(rootDir string) ([]Update, error) {
+	cmd := exec.Command("go", "list", "-m", "-u", "-json", "all")
+	cmd.Dir = rootDir
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	var updates []Update
+	dec := json.NewDecoder(stdout)
+	for dec.More() {
+		var mod goModule
+		if err := dec.Decode(&mod); err != nil {
+			return nil, err
+		}
+		if mod.Main || mod.Indirect || mod.Update == nil {
+			continue
+		}
+		updates = append(updates, Update{
+			Package: mod.Path,
+			Current: mod.Version,
+			Wanted:  mod.Version,
+			Latest:  mod.Update.Version,
+		})
+	}
+	if err := cmd.Wait(); err != nil {
+		return nil, err
+	}
+	return updates, nil
+}
+
+// goModManifest wraps the parsed go.mod file together with whether a
+// vendor/ directory was present when it was read, so WriteManifest knows
+// whether to re-vendor after rewriting requirements.
+type goModManifest struct {
+	file      *modfile.File
+	hasVendor bool
+}
+
+func (goEcosystem) ReadManifest(rootDir string) (Manifest, error) {
+	path := filepath.Join(rootDir, "go.mod")
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := modfile.Parse(path, buf, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = os.Stat(filepath.Join(rootDir, "vendor"))
+	return &goModManifest{file: f, hasVendor: err == nil}, nil
+}
+
+func (goEcosystem) WriteManifest(rootDir string, mf Manifest) error {
+	gmf := mf.(*goModManifest)
+	gmf.file.Cleanup()
+	buf, err := gmf.file.Format()
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(rootDir, "go.mod"), buf, 0644); err != nil {
+		return err
+	}
+
+	// go mod tidy (and vendor) fail whenever the current combination of
+	// requirements can't be resolved, which happens far more readily during
+	// bisection than a plain `yarn install` failure would. Wrap that in
+	// errUnresolvable so try treats it as evidence this combination is bad,
+	// rather than a hard error that aborts the whole run.
+	tidy := exec.Command("go", "mod", "tidy")
+	tidy.Dir = rootDir
+	tidy.Stdout = os.Stdout
+	tidy.Stderr = os.Stderr
+	if err := tidy.Run(); err != nil {
+		return fmt.Errorf("%w: go mod tidy: %v", errUnresolvable, err)
+	}
+
+	if gmf.hasVendor {
+		vendor := exec.Command("go", "mod", "vendor")
+		vendor.Dir = rootDir
+		vendor.Stdout = os.Stdout
+		vendor.Stderr = os.Stderr
+		if err := vendor.Run(); err != nil {
+			return fmt.Errorf("%w: go mod vendor: %v", errUnresolvable, err)
+		}
+	}
+	return nil
+}
+
+func (goEcosystem) PinVersions(mf Manifest, updates []Update) (Manifest, error) {
+	clone, err := copyGoMod(mf.(*goModManifest))
+	if err != nil {
+		return nil, err
+	}
+	for _, req := range updates {
+		version := req.Latest
+		if req.Chosen != "" {
+			version = req.Chosen
+		}
+		if err := clone.file.AddRequire(req.Package, version); err != nil {
+			return nil, err
+		}
+	}
+	clone.file.Cleanup()
+	return clone, nil
+}
+
+// copyGoMod returns a copy of `mf` by serializing and re-parsing it, mirroring
+// copyMod in the npm ecosystem.
+func copyGoMod(mf *goModManifest) (*goModManifest, error) {
+	buf, err := mf.file.Format()
+	if err != nil {
+		return nil, err
+	}
+	f, err := modfile.Parse("go.mod", buf, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &goModManifest{file: f, hasVendor: mf.hasVendor}, nil
+}
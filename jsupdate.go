@@ -1,7 +1,7 @@
 package main
 
 import (
-	"bufio"
+	"bytes"
 	"encoding/json"
 	"errors"
 	"flag"
@@ -10,8 +10,9 @@ import (
 	"log"
 	"os"
 	"os/exec"
-	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/fatih/color"
 )
@@ -20,8 +21,17 @@ func main() {
 	r := Runner{}
 	flag.StringVar(&r.TestCommand, "test", "yarn test", "The command that evaluates if an update works")
 	flag.StringVar(&r.RootDir, "c", ".", "The root directory of the module to update")
+	flag.StringVar(&r.EcosystemName, "ecosystem", "", "The ecosystem to update (npm, go); auto-detected from RootDir if unset")
 	flag.BoolVar(&r.DoCommit, "commit", false, "Commit changes")
+	flag.StringVar(&r.CommitMode, "commit-mode", "", `How to commit updates ("" for a single commit, "per-package" for one commit per update)`)
+	flag.BoolVar(&r.DoPR, "pr", false, "Push a branch and open a pull request per update, instead of committing locally")
 	flag.BoolVar(&r.Verbose, "v", false, "Show output of test runs")
+	flag.IntVar(&r.Parallelism, "j", 1, "Number of worktrees for concurrent bisection; only the two halves of the first split run concurrently, so values above 2 add no further concurrency")
+	flag.IntVar(&r.Retries, "retries", 2, "Number of times to retry a failing test before declaring the update bad")
+	flag.DurationVar(&r.RetryBackoff, "retry-backoff", time.Second, "Initial backoff between test retries, doubled after each one")
+	flag.StringVar(&r.TestFormat, "test-format", "", `Test output format to parse for individual failures ("json" for go test -json or jest --json); when set, retries only rerun the tests that failed`)
+	flag.StringVar(&r.ReportPath, "report", "", "Write a machine-readable report of every update considered to this path")
+	flag.StringVar(&r.ReportFormat, "report-format", "", `Report format ("json", the default, or "junit")`)
 	flag.Parse()
 
 	if err := r.Run(); err != nil {
@@ -32,34 +42,100 @@ func main() {
 
 // Runner holds the state for an update run
 type Runner struct {
-	RootDir             string
-	TestCommand         string
-	DoCommit            bool
-	Verbose             bool
-	OriginalPackageJSON *PackageJSON
+	RootDir          string
+	TestCommand      string
+	DoCommit         bool
+	CommitMode       string
+	DoPR             bool
+	Verbose          bool
+	EcosystemName    string
+	Parallelism      int
+	Retries          int
+	RetryBackoff     time.Duration
+	TestFormat       string
+	ReportPath       string
+	ReportFormat     string
+	Ecosystem        Ecosystem
+	OriginalManifest Manifest
+
+	// Env holds extra environment variables for this Runner's Install calls
+	// (e.g. a private YARN_CACHE_FOLDER). It is set on the copies of Runner
+	// handed to tryParallel's branches, and empty on the root Runner.
+	Env []string
+
+	// pool and printMu are shared by value-copies of Runner created for
+	// concurrent bisect branches: pool hands out worktrees to run in, and
+	// printMu serializes their progress output. report, when -report is
+	// set, accumulates test attempts the same way.
+	pool    *worktreePool
+	printMu *sync.Mutex
+	report  *reportRecorder
+
+	// policy holds the upgrade rules loaded from .jsupdate.yaml (or
+	// package.json's "jsupdate" key), and policySkipped records which
+	// packages applyPolicy excluded because of it, for reporting.
+	policy        *Policy
+	policySkipped map[string]bool
 }
 
-func (r *Runner) Run() error {
-	cmd := exec.Command("yarn", "install")
-	cmd.Dir = r.RootDir
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
+func (r *Runner) Run() (err error) {
+	r.printMu = &sync.Mutex{}
+
+	var candidates, goodUpdates []Update
+	var manifestDiff string
+	if r.ReportPath != "" {
+		r.report = newReportRecorder()
+		defer func() {
+			report := r.buildReport(candidates, goodUpdates, manifestDiff)
+			if werr := writeReport(r.ReportPath, r.ReportFormat, report); werr != nil && err == nil {
+				err = werr
+			}
+		}()
+	}
+
+	if r.Ecosystem == nil {
+		eco, err := detectEcosystem(r.RootDir, r.EcosystemName)
+		if err != nil {
+			return err
+		}
+		r.Ecosystem = eco
+	}
+
+	if r.Parallelism > 1 {
+		pool, err := newWorktreePool(r.RootDir, r.Parallelism)
+		if err != nil {
+			return err
+		}
+		defer pool.close()
+		r.pool = pool
+	}
+
+	if err := r.Ecosystem.Install(r.RootDir, r.Env); err != nil {
 		return err
 	}
 
-	var err error
-	r.OriginalPackageJSON, err = r.readPackageJSON()
+	r.OriginalManifest, err = r.Ecosystem.ReadManifest(r.RootDir)
 	if err != nil {
 		return err
 	}
 
-	updates, err := r.getUpdates()
+	updates, err := r.Ecosystem.Outdated(r.RootDir)
+	if err != nil {
+		return err
+	}
+	candidates = updates
+
+	r.policy, err = loadPolicy(r.RootDir)
+	if err != nil {
+		return err
+	}
+	r.policySkipped = map[string]bool{}
+	updates, err = r.applyPolicy(updates)
 	if err != nil {
 		return err
 	}
 
-	initialTestPassed, err := r.test()
+	initialTestPassed, err := r.instrumentedTest(packageNames(updates))()
 	if err != nil {
 		return err
 	}
@@ -68,21 +144,24 @@ func (r *Runner) Run() error {
 		return nil
 	}
 
-	goodUpdates, err := r.try(updates, "")
+	goodUpdates, err = r.try(updates, "")
 	if err != nil {
-		_ = r.writePackageJSON(r.OriginalPackageJSON)
+		_ = r.Ecosystem.WriteManifest(r.RootDir, r.OriginalManifest)
 		return err
 	}
 
-	// rewrite the mod file with the updated packages
-	mod := copyMod(r.OriginalPackageJSON)
-	setVersions(mod, goodUpdates)
-	if err := r.writePackageJSON(mod); err != nil {
-		_ = r.writePackageJSON(r.OriginalPackageJSON)
+	// rewrite the manifest with the updated packages
+	mod, err := r.Ecosystem.PinVersions(r.OriginalManifest, goodUpdates)
+	if err != nil {
+		_ = r.Ecosystem.WriteManifest(r.RootDir, r.OriginalManifest)
+		return err
+	}
+	if err := r.Ecosystem.WriteManifest(r.RootDir, mod); err != nil {
+		_ = r.Ecosystem.WriteManifest(r.RootDir, r.OriginalManifest)
 		return err
 	}
 
-	finalTestPassed, err := r.test()
+	finalTestPassed, err := r.instrumentedTest(packageNames(goodUpdates))()
 	if err != nil {
 		return err
 	}
@@ -91,9 +170,20 @@ func (r *Runner) Run() error {
 		return nil
 	}
 
+	// snapshot the manifest diff now, before commitUpdates commits it: once
+	// that runs, the working tree is clean and `git diff` would come back
+	// empty.
+	if diff, derr := gitDiff(r.RootDir); derr == nil {
+		manifestDiff = diff
+	}
+
 	for _, req := range goodUpdates {
+		version := req.Latest
+		if req.Chosen != "" {
+			version = req.Chosen
+		}
 		fmt.Printf("%s: %s %s -> %s\n", color.GreenString("package upgraded"),
-			req.Package, req.Current, req.Latest)
+			req.Package, req.Current, version)
 	}
 	for _, req := range updates {
 		if !inUpdates(goodUpdates, req.Package) {
@@ -102,25 +192,9 @@ func (r *Runner) Run() error {
 		}
 	}
 
-	if r.DoCommit && len(goodUpdates) > 0 {
-		message := []string{"Update package.json", ""}
-		for _, req := range goodUpdates {
-				message = append(message, fmt.Sprintf("* upgrade %s from %s to %s",
-					req.Package, req.Current, req.Latest))
-			}
-		cmd := exec.Command("git", "-C", r.RootDir, "add", "-A")
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		cmd.Dir = r.RootDir
-		if err := cmd.Run(); err != nil {
-			return fmt.Errorf("git add failed: %v", err)
-		}
-		cmd = exec.Command("git", "commit", "-m", strings.Join(message, "\n"))
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		cmd.Dir = r.RootDir
-		if err := cmd.Run(); err != nil {
-			return fmt.Errorf("git commit failed: %v", err)
+	if (r.DoCommit || r.DoPR) && len(goodUpdates) > 0 {
+		if err := r.commitUpdates(goodUpdates); err != nil {
+			return err
 		}
 	}
 
@@ -131,43 +205,59 @@ func (r *Runner) Run() error {
 // tests fail, it invokes itself recursively with a smaller set of updates. Returns a list of
 // the updates that passed the test.
 func (r Runner) try(updates []Update, indent string) ([]Update, error) {
-	fmt.Printf("%strying %d updates\n", indent, len(updates))
+	r.logf("%strying %d updates\n", indent, len(updates))
 	for _, req := range updates {
-		fmt.Printf("%s  %s: %s -> %s\n", indent, req.Package, req.Current, req.Latest)
+		r.logf("%s  %s: %s -> %s\n", indent, req.Package, req.Current, req.Latest)
 	}
 
 	if len(updates) == 0 {
 		return nil, nil
 	}
 
-	mod := copyMod(r.OriginalPackageJSON)
-	setVersions(mod, updates)
-	err := r.writePackageJSON(mod)
+	mod, err := r.Ecosystem.PinVersions(r.OriginalManifest, updates)
 	if err != nil {
 		return nil, err
 	}
 
-	fmt.Printf("%s  yarn install\n", indent)
-	cmd := exec.Command("yarn", "install")
-	cmd.Dir = r.RootDir
-	if err := cmd.Run(); err != nil {
-		return nil, err
-	}
+	var ok bool
+	if err := r.Ecosystem.WriteManifest(r.RootDir, mod); err != nil {
+		if !errors.Is(err, errUnresolvable) {
+			return nil, err
+		}
+		r.logf("%s  dependencies could not be resolved, treating as a failing combination: %v\n", indent, err)
+	} else {
+		r.logf("%s  installing\n", indent)
+		if err := r.Ecosystem.Install(r.RootDir, r.Env); err != nil {
+			return nil, err
+		}
 
-	ok, err := r.test()
-	if err != nil {
-		return nil, err
+		ok, err = r.instrumentedTest(packageNames(updates))()
+		if err != nil {
+			return nil, err
+		}
 	}
 	if ok {
-		fmt.Printf("%s  test passed\n", indent)
+		r.logf("%s  test passed\n", indent)
 		return updates, nil
 	}
 
-	fmt.Printf("%s  test failed\n", indent)
+	r.logf("%s  test failed\n", indent)
 
-	// if we are testing only one package, and it fails, then this package
-	// is bad, and we shouldn't include it in the update
+	// if we are testing only one package, and it fails, see if the ecosystem
+	// can bisect across the versions between Current and Latest to find a
+	// newer-than-Current version that still passes, rather than giving up
+	// on the package entirely.
 	if len(updates) == 1 {
+		if vb, ok := r.Ecosystem.(versionBisector); ok {
+			chosen, err := vb.BisectVersion(r.RootDir, r.OriginalManifest, updates[0], r.Env, r.instrumentedTest([]string{updates[0].Package}), indent)
+			if err != nil {
+				return nil, err
+			}
+			if chosen.Package == "" {
+				return []Update{}, nil
+			}
+			return []Update{chosen}, nil
+		}
 		return []Update{}, nil
 	}
 
@@ -176,175 +266,292 @@ func (r Runner) try(updates []Update, indent string) ([]Update, error) {
 	// broken
 	requireA, requireB := bisect(updates)
 
-	successA, err := r.try(requireA, indent + "  ")
-	if err != nil {
-		return nil, err
+	if len(requireA) == 0 || len(requireB) == 0 {
+		// every remaining update shares the same Group, so bisect couldn't
+		// actually split them apart: recursing would just hand us this same
+		// unsplit set back forever. We already know the whole group fails
+		// together (the test above just failed), so reject it as a unit.
+		r.logf("%s  updates form a single atomic group, rejecting as a unit\n", indent)
+		return []Update{}, nil
 	}
-	successB, err := r.try(requireB, indent + "  ")
-	if err != nil {
-		return nil, err
+
+	var successA, successB []Update
+	if r.pool != nil {
+		successA, successB, err = r.tryParallel(requireA, requireB, indent)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		successA, err = r.try(requireA, indent+"  ")
+		if err != nil {
+			return nil, err
+		}
+		successB, err = r.try(requireB, indent+"  ")
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	goodUpdates := append(successA, successB...)
-	fmt.Printf("%skeeping %d of %d updates:\n", indent, len(goodUpdates), len(updates))
+	r.logf("%skeeping %d of %d updates:\n", indent, len(goodUpdates), len(updates))
 	for _, req := range goodUpdates {
-		fmt.Printf("%s  %s: %s -> %s\n", indent, req.Package, req.Current, req.Latest)
+		r.logf("%s  %s: %s -> %s\n", indent, req.Package, req.Current, req.Latest)
 	}
 
 	return goodUpdates, nil
 }
 
-// test runs the tests to determine if an upgrade was successful
-func (r Runner) test() (bool, error) {
-	log.Printf("running test: %s", r.TestCommand)
-	cmd := exec.Command("/bin/sh", "-c", r.TestCommand)
-	cmd.Dir = r.RootDir
-	if r.Verbose {
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
+// tryParallel runs r.try(requireA, ...) and r.try(requireB, ...) concurrently,
+// each against its own worktree leased from r.pool, so that a bisect split
+// doesn't have to wait for one half to finish installing and testing before
+// starting the other.
+//
+// Each branch clears its own copy's pool before recursing into branch.try,
+// so any further splits inside that branch run sequentially in the
+// worktree it already holds instead of calling r.pool.acquire again. Without
+// this, a branch holding a worktree can recurse into tryParallel and block
+// acquiring worktrees for its own children; with -j N that can leave every
+// worktree held by a frame that's itself stuck waiting for one — a
+// permanent deadlock.
+func (r Runner) tryParallel(requireA, requireB []Update, indent string) ([]Update, []Update, error) {
+	type branchResult struct {
+		updates []Update
+		err     error
+	}
+
+	run := func(updates []Update) branchResult {
+		dir, err := r.pool.acquire()
+		if err != nil {
+			return branchResult{err: err}
+		}
+		defer r.pool.release(dir)
+
+		branch := r
+		branch.RootDir = dir
+		branch.Env = append(append([]string{}, r.Env...), "YARN_CACHE_FOLDER="+r.pool.yarnCacheDir(dir))
+		branch.pool = nil
+
+		updatesResult, err := branch.try(updates, indent+"  ")
+		return branchResult{updates: updatesResult, err: err}
 	}
-	err := cmd.Run()
-	var exitErr *exec.ExitError
-	if errors.As(err, &exitErr) {
-		return false, nil
+
+	resultA := make(chan branchResult, 1)
+	go func() { resultA <- run(requireA) }()
+	resB := run(requireB)
+	resA := <-resultA
+
+	if resA.err != nil {
+		return nil, nil, resA.err
 	}
-	if err != nil {
-		return false, fmt.Errorf("cannot run test program: %s", err)
+	if resB.err != nil {
+		return nil, nil, resB.err
 	}
-	return true, nil
+	return resA.updates, resB.updates, nil
 }
 
-type Update struct {
-	Package string
-	Current string
-	Wanted string
-	Latest string
+// logf prints a progress line, serialized by printMu so that concurrent
+// bisect branches (see tryParallel) don't interleave their output.
+func (r Runner) logf(format string, args ...interface{}) {
+	if r.printMu != nil {
+		r.printMu.Lock()
+		defer r.printMu.Unlock()
+	}
+	fmt.Printf(format, args...)
 }
 
-func (r Runner) getUpdates() ([]Update, error) {
-	log.Printf("running npm outdated")
-	cmd := exec.Command("npm", "outdated")
-	cmd.Stderr = os.Stderr
-	cmd.Dir = r.RootDir
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return nil, err
-	}
-	if err := cmd.Start(); err != nil {
-		return nil, err
+// test runs the tests to determine if an upgrade was successful. A failing
+// run is retried up to r.Retries times, with exponential backoff starting
+// at r.RetryBackoff, and only reported as a failure if every attempt fails.
+// This keeps a single flaky test from causing bisection to incorrectly
+// quarantine a perfectly good package upgrade.
+func (r Runner) test() (bool, error) {
+	backoff := r.RetryBackoff
+	if backoff <= 0 {
+		backoff = time.Second
 	}
-	var updates []Update
-
-	stdoutScanner := bufio.NewScanner(stdout)
-	stdoutScanner.Scan()  // first line is a header
-	for stdoutScanner.Scan() {
-		fmt.Println(stdoutScanner.Text())
-		parts := strings.Fields(stdoutScanner.Text())
-		update := Update{
-			Package: parts[0],
-			Current: parts[1],
-			Wanted: parts[2],
-			Latest: parts[3],
+
+	var failedTests []string
+	for attempt := 0; ; attempt++ {
+		ok, failed, err := r.runTest(failedTests)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return true, nil
 		}
-		updates = append(updates, update)
+		if attempt >= r.Retries {
+			return false, nil
+		}
+		failedTests = failed
+		r.logf("test failed (attempt %d/%d), retrying in %s\n", attempt+1, r.Retries+1, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
 	}
-	if err := stdoutScanner.Err(); err!= nil {
-		return nil, err
+}
+
+// runTest runs TestCommand once and reports whether it passed. If
+// r.TestFormat is "json" and failedTests is non-empty (i.e. this is a retry
+// following a parsed failure), the command is narrowed to only rerun those
+// tests via `-run` (go test) or `--testNamePattern` (jest). When
+// r.TestFormat is "json", it also returns the names of any tests that
+// failed, so the next retry can narrow further.
+func (r Runner) runTest(failedTests []string) (bool, []string, error) {
+	command := r.TestCommand
+	if r.TestFormat == "json" && len(failedTests) > 0 {
+		pattern := strings.Join(failedTests, "|")
+		if strings.Contains(command, "jest") {
+			command = fmt.Sprintf("%s --testNamePattern %q", command, pattern)
+		} else {
+			command = fmt.Sprintf("%s -run %q", command, pattern)
+		}
 	}
-	if err := cmd.Wait(); err != nil {
+
+	log.Printf("running test: %s", command)
+	cmd := exec.Command("/bin/sh", "-c", command)
+	cmd.Dir = r.RootDir
+
+	if r.TestFormat != "json" {
+		if r.Verbose {
+			cmd.Stdout = os.Stdout
+			cmd.Stderr = os.Stderr
+		}
+		err := cmd.Run()
 		var exitErr *exec.ExitError
 		if errors.As(err, &exitErr) {
-			if (exitErr.ExitCode() == 1) {
-				err = nil
-			}
+			return false, nil, nil
 		}
 		if err != nil {
-			return nil, err
+			return false, nil, fmt.Errorf("cannot run test program: %s", err)
 		}
+		return true, nil, nil
 	}
-	return updates, nil
-}
-
-type PackageJSON struct {
-	raw json.RawMessage
-	Dependencies map[string]string `json:"dependencies"`
-	DevDependencies map[string]string `json:"devDependencies"`
-}
 
-// readPackageJSON reads and parses package.json
-func (r Runner) readPackageJSON() (*PackageJSON, error) {
-	buf, err := ioutil.ReadFile(filepath.Join(r.RootDir, "package.json"))
+	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		return nil, err
+		return false, nil, err
+	}
+	if r.Verbose {
+		cmd.Stderr = os.Stderr
+	}
+	if err := cmd.Start(); err != nil {
+		return false, nil, err
+	}
+	buf, readErr := ioutil.ReadAll(stdout)
+	runErr := cmd.Wait()
+	if readErr != nil {
+		return false, nil, readErr
 	}
 
-	rv := PackageJSON{}
-	if err := json.Unmarshal(buf, &rv); err != nil {
-		return nil, err
+	var exitErr *exec.ExitError
+	if errors.As(runErr, &exitErr) {
+		return false, parseJSONTestOutput(buf), nil
+	}
+	if runErr != nil {
+		return false, nil, fmt.Errorf("cannot run test program: %s", runErr)
 	}
-	rv.raw = buf
+	return true, nil, nil
+}
 
-	return &rv, nil
+// parseJSONTestOutput extracts the names of failing tests from either `go
+// test -json` (newline-delimited JSON events) or `jest --json` (a single
+// JSON summary object) output.
+func parseJSONTestOutput(buf []byte) []string {
+	if failed := parseGoTestJSON(buf); len(failed) > 0 {
+		return failed
+	}
+	return parseJestJSON(buf)
 }
 
-// writePackageJSON writes `mf` to package.json.
-func (r Runner) writePackageJSON(mf *PackageJSON) (error) {
-	var m map[string]interface{}
-	if err := json.Unmarshal(mf.raw, &m); err != nil {
-		return err
+func parseGoTestJSON(buf []byte) []string {
+	var failed []string
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	for dec.More() {
+		var ev struct {
+			Action string
+			Test   string
+		}
+		if err := dec.Decode(&ev); err != nil {
+			return nil
+		}
+		if ev.Action == "fail" && ev.Test != "" {
+			failed = append(failed, ev.Test)
+		}
 	}
-	m["dependencies"] = mf.Dependencies
-	m["devDependencies"] = mf.DevDependencies
+	return failed
+}
 
-	buf, err := json.MarshalIndent(m, "", "\t")
-	if err != nil {
-		return err
+func parseJestJSON(buf []byte) []string {
+	var report struct {
+		TestResults []struct {
+			AssertionResults []struct {
+				FullName string
+				Status   string
+			}
+		}
+	}
+	if err := json.Unmarshal(buf, &report); err != nil {
+		return nil
+	}
+	var failed []string
+	for _, file := range report.TestResults {
+		for _, a := range file.AssertionResults {
+			if a.Status == "failed" {
+				failed = append(failed, a.FullName)
+			}
+		}
 	}
-	return ioutil.WriteFile(filepath.Join(r.RootDir, "package.json"), buf, 0644)
+	return failed
 }
 
+// Update describes a single available package/module update, independent of
+// which Ecosystem produced it.
+type Update struct {
+	Package string
+	Current string
+	Wanted  string
+	Latest  string
+
+	// Chosen is the version that `try` actually settled on for this package,
+	// when that is not Latest: when the jump from Current to Latest breaks
+	// the tests, an ecosystem that implements versionBisector may pin it to
+	// an intermediate version (possibly a pre-release) that is newer than
+	// Current but older than Latest. It is empty when the package was kept
+	// at Latest (see chosenVersion).
+	Chosen string
+
+	// Group is the name of the policy group (see Policy.Groups) this update
+	// belongs to, if any. bisect keeps every update sharing a Group on the
+	// same side of a split, so the group can only ever be accepted or
+	// rejected as a whole.
+	Group string
+}
 
 // bisect returns two require lists, each containing approximately half of the
-// items in `updates`
+// items in `updates`. Updates that share a non-empty Group are always placed
+// on the same side, so a group is never split across the two halves.
 func bisect(updates []Update) ([]Update, []Update) {
 	a, b := []Update{}, []Update{}
-	for i := range updates {
-		if i % 2 == 0 {
-			a = append(a, updates[i])
-		} else {
-			b = append(b, updates[i])
+	groupSide := map[string]int{}
+	next := 0
+	for _, u := range updates {
+		side, seen := 0, false
+		if u.Group != "" {
+			side, seen = groupSide[u.Group]
 		}
-	}
-	return a,b
-}
-
-// setVersions updates the requirements in `mf` with the updates described
-// by `updates`.
-func setVersions(mf *PackageJSON, updates []Update) {
-	for _, req := range updates {
-		_, ok := mf.DevDependencies[req.Package]
-		if ok {
-			mf.DevDependencies[req.Package] = req.Latest
+		if u.Group == "" || !seen {
+			side = next
+			next++
+			if u.Group != "" {
+				groupSide[u.Group] = side
+			}
+		}
+		if side%2 == 0 {
+			a = append(a, u)
 		} else {
-			mf.Dependencies[req.Package] = req.Latest
+			b = append(b, u)
 		}
 	}
-}
-
-// copyMod returns a copy of `mf` by serializing and re-parsing it.
-func copyMod(mf *PackageJSON) *PackageJSON {
-	copy := PackageJSON{
-		raw: mf.raw,
-		Dependencies: map[string]string{},
-		DevDependencies: map[string]string{},
-	}
-	for k,v := range mf.Dependencies {
-		copy.Dependencies[k] = v
-	}
-	for k,v := range mf.DevDependencies {
-		copy.DevDependencies[k] = v
-	}
-	return &copy
+	return a, b
 }
 
 func inUpdates(updates []Update, pkg string) bool {
@@ -355,4 +562,3 @@ func inUpdates(updates []Update, pkg string) bool {
 	}
 	return false
 }
-
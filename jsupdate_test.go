@@ -0,0 +1,96 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func pkgNames(updates []Update) []string {
+	names := make([]string, len(updates))
+	for i, u := range updates {
+		names[i] = u.Package
+	}
+	return names
+}
+
+func TestBisect(t *testing.T) {
+	tests := []struct {
+		name    string
+		updates []Update
+		wantA   []string
+		wantB   []string
+	}{
+		{
+			name: "no groups alternate",
+			updates: []Update{
+				{Package: "a"}, {Package: "b"}, {Package: "c"}, {Package: "d"},
+			},
+			wantA: []string{"a", "c"},
+			wantB: []string{"b", "d"},
+		},
+		{
+			name: "a group stays together on one side",
+			updates: []Update{
+				{Package: "a"},
+				{Package: "g1", Group: "babel"},
+				{Package: "g2", Group: "babel"},
+				{Package: "b"},
+			},
+			wantA: []string{"a", "b"},
+			wantB: []string{"g1", "g2"},
+		},
+		{
+			name: "group members need not be contiguous",
+			updates: []Update{
+				{Package: "g1", Group: "babel"},
+				{Package: "a"},
+				{Package: "g2", Group: "babel"},
+			},
+			wantA: []string{"g1", "g2"},
+			wantB: []string{"a"},
+		},
+		{
+			name: "a single all-encompassing group lands entirely on one side",
+			updates: []Update{
+				{Package: "g1", Group: "babel"},
+				{Package: "g2", Group: "babel"},
+			},
+			wantA: []string{"g1", "g2"},
+			wantB: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := bisect(tt.updates)
+			if got := pkgNames(a); !reflect.DeepEqual(got, tt.wantA) {
+				t.Errorf("side A = %v, want %v", got, tt.wantA)
+			}
+			if got := pkgNames(b); !reflect.DeepEqual(got, tt.wantB) {
+				t.Errorf("side B = %v, want %v", got, tt.wantB)
+			}
+		})
+	}
+}
+
+func TestBisectNeverSplitsAGroup(t *testing.T) {
+	updates := []Update{
+		{Package: "g1", Group: "babel"},
+		{Package: "a"},
+		{Package: "g2", Group: "babel"},
+		{Package: "g3", Group: "babel"},
+		{Package: "b"},
+	}
+	a, b := bisect(updates)
+
+	side := map[string]string{}
+	for _, u := range a {
+		side[u.Package] = "a"
+	}
+	for _, u := range b {
+		side[u.Package] = "b"
+	}
+	if side["g1"] != side["g2"] || side["g2"] != side["g3"] {
+		t.Fatalf("group members split across sides: g1=%s g2=%s g3=%s", side["g1"], side["g2"], side["g3"])
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// npmEcosystem drives updates for yarn/npm projects via package.json.
+type npmEcosystem struct{}
+
+func (npmEcosystem) Install(rootDir string, env []string) error {
+	cmd := exec.Command("yarn", "install")
+	cmd.Dir = rootDir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	return cmd.Run()
+}
+
+func (npmEcosystem) Outdated(rootDir string) ([]Update, error) {
+	log.Printf("running npm outdated")
+	cmd := exec.Command("npm", "outdated")
+	cmd.Stderr = os.Stderr
+	cmd.Dir = rootDir
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	var updates []Update
+
+	stdoutScanner := bufio.NewScanner(stdout)
+	stdoutScanner.Scan() // first line is a header
+	for stdoutScanner.Scan() {
+		fmt.Println(stdoutScanner.Text())
+		parts := strings.Fields(stdoutScanner.Text())
+		update := Update{
+			Package: parts[0],
+			Current: parts[1],
+			Wanted:  parts[2],
+			Latest:  parts[3],
+		}
+		updates = append(updates, update)
+	}
+	if err := stdoutScanner.Err(); err != nil {
+		return nil, err
+	}
+	if err := cmd.Wait(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			if exitErr.ExitCode() == 1 {
+				err = nil
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return updates, nil
+}
+
+func (npmEcosystem) ReadManifest(rootDir string) (Manifest, error) {
+	return readPackageJSON(rootDir)
+}
+
+func (npmEcosystem) WriteManifest(rootDir string, mf Manifest) error {
+	return writePackageJSON(rootDir, mf.(*PackageJSON))
+}
+
+func (npmEcosystem) PinVersions(mf Manifest, updates []Update) (Manifest, error) {
+	mod := copyMod(mf.(*PackageJSON))
+	setVersions(mod, updates)
+	return mod, nil
+}
+
+// BisectVersion queries the full list of published versions for update.Package
+// and bisects between Current (known good) and Latest (known bad) to find
+// the newest version that still passes, which is what a human maintainer
+// would do by hand when a major bump breaks things. It returns a zero Update
+// if no version newer than Current passes. env is passed through to Install
+// unchanged, so a bisection running in its own worktree (see -j) installs
+// into its own cache rather than the default one.
+func (e npmEcosystem) BisectVersion(rootDir string, mf Manifest, update Update, env []string, test func() (bool, error), indent string) (Update, error) {
+	original := mf.(*PackageJSON)
+
+	versions, err := npmVersions(rootDir, update.Package)
+	if err != nil {
+		fmt.Printf("%s  could not list versions for %s: %v\n", indent, update.Package, err)
+		return Update{}, nil
+	}
+
+	lo := indexOf(versions, update.Current)
+	hi := indexOf(versions, update.Latest)
+	if lo == -1 || hi == -1 || hi <= lo+1 {
+		return Update{}, nil
+	}
+
+	for hi-lo > 1 {
+		mid := (lo + hi) / 2
+		candidate := update
+		candidate.Chosen = versions[mid]
+		fmt.Printf("%s  trying %s@%s\n", indent, update.Package, candidate.Chosen)
+
+		mod := copyMod(original)
+		setVersions(mod, []Update{candidate})
+		if err := writePackageJSON(rootDir, mod); err != nil {
+			return Update{}, err
+		}
+		if err := e.Install(rootDir, env); err != nil {
+			return Update{}, err
+		}
+		ok, err := test()
+		if err != nil {
+			return Update{}, err
+		}
+		if ok {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+
+	if versions[lo] == update.Current {
+		// nothing newer than Current passed
+		return Update{}, nil
+	}
+
+	update.Chosen = versions[lo]
+	fmt.Printf("%s  keeping %s@%s (bisected)\n", indent, update.Package, update.Chosen)
+	return update, nil
+}
+
+// Versions returns the full list of published versions for pkg, oldest
+// first, so applyPolicy can find the newest one within an allowed-range
+// policy.
+func (npmEcosystem) Versions(rootDir, pkg string) ([]string, error) {
+	return npmVersions(rootDir, pkg)
+}
+
+// VulnerablePackages returns the set of packages flagged by `npm audit
+// --json`, for narrowing updates down to security fixes only (see
+// Policy.SecurityOnly).
+func (npmEcosystem) VulnerablePackages(rootDir string) (map[string]bool, error) {
+	cmd := exec.Command("npm", "audit", "--json")
+	cmd.Dir = rootDir
+	// npm audit exits non-zero when it finds vulnerabilities; the report is
+	// still on stdout, so ignore the error and parse what we got.
+	out, _ := cmd.Output()
+
+	var report struct {
+		Vulnerabilities map[string]json.RawMessage `json:"vulnerabilities"`
+	}
+	if err := json.Unmarshal(out, &report); err != nil {
+		return nil, fmt.Errorf("parsing npm audit output: %v", err)
+	}
+
+	vulnerable := make(map[string]bool, len(report.Vulnerabilities))
+	for pkg := range report.Vulnerabilities {
+		vulnerable[pkg] = true
+	}
+	return vulnerable, nil
+}
+
+// ChangelogURL looks up pkg's repository URL on the npm registry and
+// guesses at a CHANGELOG.md path within it, for inclusion in per-package
+// commit messages.
+func (npmEcosystem) ChangelogURL(rootDir, pkg string) (string, error) {
+	cmd := exec.Command("npm", "view", pkg, "repository.url")
+	cmd.Dir = rootDir
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("npm view %s repository.url: %v", pkg, err)
+	}
+
+	repo := strings.TrimSpace(string(out))
+	repo = strings.TrimPrefix(repo, "git+")
+	repo = strings.TrimSuffix(repo, ".git")
+	repo = strings.TrimPrefix(repo, "git://")
+	repo = strings.TrimPrefix(repo, "https://")
+	repo = strings.TrimPrefix(repo, "http://")
+	if repo == "" {
+		return "", nil
+	}
+	return fmt.Sprintf("https://%s/blob/HEAD/CHANGELOG.md", repo), nil
+}
+
+// npmVersions returns the full list of published versions for pkg, as
+// reported by the npm registry.
+func npmVersions(rootDir, pkg string) ([]string, error) {
+	cmd := exec.Command("npm", "view", pkg, "versions", "--json")
+	cmd.Dir = rootDir
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("npm view %s versions: %v", pkg, err)
+	}
+
+	var versions []string
+	if err := json.Unmarshal(out, &versions); err != nil {
+		// a package with a single published version reports it as a bare
+		// string rather than a list
+		var single string
+		if err2 := json.Unmarshal(out, &single); err2 != nil {
+			return nil, fmt.Errorf("parsing npm view output for %s: %v", pkg, err)
+		}
+		versions = []string{single}
+	}
+	return versions, nil
+}
+
+// indexOf returns the index of v in versions, or -1 if it is not present.
+func indexOf(versions []string, v string) int {
+	for i, s := range versions {
+		if s == v {
+			return i
+		}
+	}
+	return -1
+}
+
+type PackageJSON struct {
+	raw             json.RawMessage
+	Dependencies    map[string]string `json:"dependencies"`
+	DevDependencies map[string]string `json:"devDependencies"`
+}
+
+// readPackageJSON reads and parses package.json
+func readPackageJSON(rootDir string) (*PackageJSON, error) {
+	buf, err := ioutil.ReadFile(filepath.Join(rootDir, "package.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	rv := PackageJSON{}
+	if err := json.Unmarshal(buf, &rv); err != nil {
+		return nil, err
+	}
+	rv.raw = buf
+
+	return &rv, nil
+}
+
+// writePackageJSON writes `mf` to package.json.
+func writePackageJSON(rootDir string, mf *PackageJSON) error {
+	var m map[string]interface{}
+	if err := json.Unmarshal(mf.raw, &m); err != nil {
+		return err
+	}
+	m["dependencies"] = mf.Dependencies
+	m["devDependencies"] = mf.DevDependencies
+
+	buf, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(rootDir, "package.json"), buf, 0644)
+}
+
+// setVersions updates the requirements in `mf` with the updates described
+// by `updates`.
+func setVersions(mf *PackageJSON, updates []Update) {
+	for _, req := range updates {
+		version := req.Latest
+		if req.Chosen != "" {
+			version = req.Chosen
+		}
+		_, ok := mf.DevDependencies[req.Package]
+		if ok {
+			mf.DevDependencies[req.Package] = version
+		} else {
+			mf.Dependencies[req.Package] = version
+		}
+	}
+}
+
+// copyMod returns a copy of `mf` by serializing and re-parsing it.
+func copyMod(mf *PackageJSON) *PackageJSON {
+	copy := PackageJSON{
+		raw:             mf.raw,
+		Dependencies:    map[string]string{},
+		DevDependencies: map[string]string{},
+	}
+	for k, v := range mf.Dependencies {
+		copy.Dependencies[k] = v
+	}
+	for k, v := range mf.DevDependencies {
+		copy.DevDependencies[k] = v
+	}
+	return &copy
+}
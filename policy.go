@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Policy declares per-package upgrade rules, loaded from .jsupdate.yaml or
+// the "jsupdate" key of package.json. A nil *Policy behaves as if no rules
+// were configured.
+type Policy struct {
+	Ignore       []string            `yaml:"ignore" json:"ignore"`
+	AllowedRange map[string]string   `yaml:"allowed-range" json:"allowed-range"`
+	SecurityOnly bool                `yaml:"security-only" json:"security-only"`
+	Groups       map[string][]string `yaml:"groups" json:"groups"`
+}
+
+// loadPolicy reads the upgrade policy for rootDir, preferring
+// .jsupdate.yaml and falling back to the "jsupdate" key of package.json. It
+// returns an empty Policy, not an error, when neither is present.
+func loadPolicy(rootDir string) (*Policy, error) {
+	if buf, err := ioutil.ReadFile(filepath.Join(rootDir, ".jsupdate.yaml")); err == nil {
+		var p Policy
+		if err := yaml.Unmarshal(buf, &p); err != nil {
+			return nil, fmt.Errorf("parsing .jsupdate.yaml: %v", err)
+		}
+		return &p, nil
+	}
+
+	buf, err := ioutil.ReadFile(filepath.Join(rootDir, "package.json"))
+	if err != nil {
+		return &Policy{}, nil
+	}
+	var wrapper struct {
+		Jsupdate Policy `json:"jsupdate"`
+	}
+	if err := json.Unmarshal(buf, &wrapper); err != nil {
+		return nil, fmt.Errorf("parsing package.json jsupdate policy: %v", err)
+	}
+	return &wrapper.Jsupdate, nil
+}
+
+// ignores reports whether pkg should never be upgraded.
+func (p *Policy) ignores(pkg string) bool {
+	if p == nil {
+		return false
+	}
+	for _, pattern := range p.Ignore {
+		if matchPackagePattern(pattern, pkg) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedRange returns the configured allowed-range for pkg, if any.
+func (p *Policy) allowedRange(pkg string) (string, bool) {
+	if p == nil {
+		return "", false
+	}
+	r, ok := p.AllowedRange[pkg]
+	return r, ok
+}
+
+// groupFor returns the name of the group pkg belongs to, if any.
+func (p *Policy) groupFor(pkg string) (string, bool) {
+	if p == nil {
+		return "", false
+	}
+	for name, patterns := range p.Groups {
+		for _, pattern := range patterns {
+			if matchPackagePattern(pattern, pkg) {
+				return name, true
+			}
+		}
+	}
+	return "", false
+}
+
+// matchPackagePattern matches a package name against a policy pattern,
+// supporting a trailing "*" wildcard (e.g. "@babel/*").
+func matchPackagePattern(pattern, pkg string) bool {
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(pkg, strings.TrimSuffix(pattern, "*"))
+	}
+	return pattern == pkg
+}
+
+var majorVersionRE = regexp.MustCompile(`^[\^~]?v?(\d+)`)
+
+// caretMajor extracts the major version from a caret/tilde range like "^5"
+// or "~5.1". It returns false for range syntax it doesn't recognize.
+func caretMajor(rangeSpec string) (string, bool) {
+	m := majorVersionRE.FindStringSubmatch(rangeSpec)
+	if m == nil {
+		return "", false
+	}
+	return m[1], true
+}
+
+func semverMajor(version string) (string, bool) {
+	m := majorVersionRE.FindStringSubmatch(version)
+	if m == nil {
+		return "", false
+	}
+	return m[1], true
+}
+
+// rangeLimiter is implemented by ecosystems that can enumerate a package's
+// published versions, so applyPolicy can clamp an update to the newest
+// version satisfying an allowed-range policy.
+type rangeLimiter interface {
+	Versions(rootDir, pkg string) ([]string, error)
+}
+
+// applyPolicy filters and adjusts updates according to r.policy: ignored
+// packages are dropped, allowed-range violations are clamped to the newest
+// in-range version (or dropped if none exists), security-only mode drops
+// every non-vulnerable package, and group members are tagged with their
+// group name so bisect never splits a group across branches.
+func (r Runner) applyPolicy(updates []Update) ([]Update, error) {
+	var vulnerable map[string]bool
+	if r.policy != nil && r.policy.SecurityOnly {
+		if scanner, ok := r.Ecosystem.(vulnerabilityScanner); ok {
+			var err error
+			vulnerable, err = scanner.VulnerablePackages(r.RootDir)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			r.logf("security-only policy set, but this ecosystem can't report vulnerabilities; not filtering\n")
+		}
+	}
+
+	var filtered []Update
+	for _, u := range updates {
+		if r.policy.ignores(u.Package) {
+			r.logf("skipping %s: ignored by policy\n", u.Package)
+			r.policySkipped[u.Package] = true
+			continue
+		}
+		if vulnerable != nil && !vulnerable[u.Package] {
+			r.logf("skipping %s: security-only policy and no known vulnerability\n", u.Package)
+			r.policySkipped[u.Package] = true
+			continue
+		}
+		if rangeSpec, ok := r.policy.allowedRange(u.Package); ok {
+			clamped, err := r.clampToRange(u, rangeSpec)
+			if err != nil {
+				return nil, err
+			}
+			if clamped == nil {
+				r.logf("skipping %s: no version within allowed range %q\n", u.Package, rangeSpec)
+				r.policySkipped[u.Package] = true
+				continue
+			}
+			u = *clamped
+		}
+		if group, ok := r.policy.groupFor(u.Package); ok {
+			u.Group = group
+		}
+		filtered = append(filtered, u)
+	}
+	return filtered, nil
+}
+
+// clampToRange returns a copy of u with Latest reduced to the newest
+// published version matching rangeSpec's major version, or nil if no
+// version newer than Current is in range. It also returns nil when Latest
+// is out of range and the ecosystem cannot list published versions.
+func (r Runner) clampToRange(u Update, rangeSpec string) (*Update, error) {
+	allowedMajor, ok := caretMajor(rangeSpec)
+	if !ok {
+		// unrecognized range syntax; leave the update as-is rather than
+		// guess wrong
+		return &u, nil
+	}
+	if major, ok := semverMajor(u.Latest); ok && major == allowedMajor {
+		return &u, nil
+	}
+
+	rl, ok := r.Ecosystem.(rangeLimiter)
+	if !ok {
+		// can't enumerate versions to find one within range; be
+		// conservative and drop the update rather than risk crossing it
+		return nil, nil
+	}
+	versions, err := rl.Versions(r.RootDir, u.Package)
+	if err != nil {
+		return nil, err
+	}
+
+	currentIdx := indexOf(versions, u.Current)
+	bestIdx := -1
+	for i, v := range versions {
+		if major, ok := semverMajor(v); ok && major == allowedMajor {
+			bestIdx = i
+		}
+	}
+	// versions is ordered oldest to newest (see npmVersions), so bestIdx
+	// must come strictly after currentIdx or the "best" in-range version is
+	// actually older than what's installed — e.g. Current is already a v6
+	// and allowed-range caps at v5, and clamping there would be a
+	// downgrade, not an upgrade.
+	if bestIdx == -1 || bestIdx <= currentIdx {
+		return nil, nil
+	}
+	u.Latest = versions[bestIdx]
+	return &u, nil
+}
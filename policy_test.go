@@ -0,0 +1,145 @@
+package main
+
+import "testing"
+
+func TestMatchPackagePattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		pkg     string
+		want    bool
+	}{
+		{"react", "react", true},
+		{"react", "react-dom", false},
+		{"@babel/*", "@babel/core", true},
+		{"@babel/*", "@babel/preset-env", true},
+		{"@babel/*", "@types/babel", false},
+		{"*", "anything", true},
+	}
+	for _, tt := range tests {
+		if got := matchPackagePattern(tt.pattern, tt.pkg); got != tt.want {
+			t.Errorf("matchPackagePattern(%q, %q) = %v, want %v", tt.pattern, tt.pkg, got, tt.want)
+		}
+	}
+}
+
+func TestCaretMajor(t *testing.T) {
+	tests := []struct {
+		rangeSpec string
+		wantMajor string
+		wantOK    bool
+	}{
+		{"^5", "5", true},
+		{"^5.1.0", "5", true},
+		{"~5.1.0", "5", true},
+		{"5", "5", true},
+		{"v5", "5", true},
+		{">=5", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		major, ok := caretMajor(tt.rangeSpec)
+		if ok != tt.wantOK || major != tt.wantMajor {
+			t.Errorf("caretMajor(%q) = (%q, %v), want (%q, %v)", tt.rangeSpec, major, ok, tt.wantMajor, tt.wantOK)
+		}
+	}
+}
+
+func TestSemverMajor(t *testing.T) {
+	tests := []struct {
+		version   string
+		wantMajor string
+		wantOK    bool
+	}{
+		{"5.2.3", "5", true},
+		{"v5.2.3", "5", true},
+		{"6.0.0-beta.1", "6", true},
+		{"latest", "", false},
+	}
+	for _, tt := range tests {
+		major, ok := semverMajor(tt.version)
+		if ok != tt.wantOK || major != tt.wantMajor {
+			t.Errorf("semverMajor(%q) = (%q, %v), want (%q, %v)", tt.version, major, ok, tt.wantMajor, tt.wantOK)
+		}
+	}
+}
+
+// fakeRangeEcosystem is a minimal Ecosystem + rangeLimiter whose Versions
+// method returns a fixed, oldest-to-newest list, for exercising clampToRange.
+type fakeRangeEcosystem struct {
+	versions []string
+}
+
+func (fakeRangeEcosystem) Install(rootDir string, env []string) error      { return nil }
+func (fakeRangeEcosystem) Outdated(rootDir string) ([]Update, error)       { return nil, nil }
+func (fakeRangeEcosystem) ReadManifest(rootDir string) (Manifest, error)   { return nil, nil }
+func (fakeRangeEcosystem) WriteManifest(rootDir string, mf Manifest) error { return nil }
+func (fakeRangeEcosystem) PinVersions(mf Manifest, updates []Update) (Manifest, error) {
+	return nil, nil
+}
+func (f fakeRangeEcosystem) Versions(rootDir, pkg string) ([]string, error) {
+	return f.versions, nil
+}
+
+func TestClampToRange(t *testing.T) {
+	versions := []string{"4.0.0", "5.0.0", "5.1.0", "6.0.0", "7.0.0"}
+
+	t.Run("clamps to the newest version within the allowed major", func(t *testing.T) {
+		r := Runner{Ecosystem: fakeRangeEcosystem{versions: versions}}
+		u := Update{Package: "webpack", Current: "4.0.0", Latest: "7.0.0"}
+		got, err := r.clampToRange(u, "^5")
+		if err != nil {
+			t.Fatalf("clampToRange: %v", err)
+		}
+		if got == nil || got.Latest != "5.1.0" {
+			t.Fatalf("clampToRange = %+v, want Latest 5.1.0", got)
+		}
+	})
+
+	t.Run("refuses to downgrade when Current already exceeds the range", func(t *testing.T) {
+		r := Runner{Ecosystem: fakeRangeEcosystem{versions: versions}}
+		u := Update{Package: "webpack", Current: "6.0.0", Latest: "7.0.0"}
+		got, err := r.clampToRange(u, "^5")
+		if err != nil {
+			t.Fatalf("clampToRange: %v", err)
+		}
+		if got != nil {
+			t.Fatalf("clampToRange = %+v, want nil (no in-range version newer than Current)", got)
+		}
+	})
+
+	t.Run("leaves the update alone when already within the allowed major", func(t *testing.T) {
+		r := Runner{Ecosystem: fakeRangeEcosystem{versions: versions}}
+		u := Update{Package: "webpack", Current: "5.0.0", Latest: "5.1.0"}
+		got, err := r.clampToRange(u, "^5")
+		if err != nil {
+			t.Fatalf("clampToRange: %v", err)
+		}
+		if got == nil || got.Latest != "5.1.0" {
+			t.Fatalf("clampToRange = %+v, want unchanged Latest 5.1.0", got)
+		}
+	})
+
+	t.Run("leaves the update alone on unrecognized range syntax", func(t *testing.T) {
+		r := Runner{Ecosystem: fakeRangeEcosystem{versions: versions}}
+		u := Update{Package: "webpack", Current: "4.0.0", Latest: "7.0.0"}
+		got, err := r.clampToRange(u, ">=5")
+		if err != nil {
+			t.Fatalf("clampToRange: %v", err)
+		}
+		if got == nil || got.Latest != "7.0.0" {
+			t.Fatalf("clampToRange = %+v, want unchanged Latest 7.0.0", got)
+		}
+	})
+
+	t.Run("drops the update when the ecosystem can't enumerate versions", func(t *testing.T) {
+		r := Runner{Ecosystem: goEcosystem{}} // goEcosystem has no Versions method
+		u := Update{Package: "webpack", Current: "4.0.0", Latest: "7.0.0"}
+		got, err := r.clampToRange(u, "^5")
+		if err != nil {
+			t.Fatalf("clampToRange: %v", err)
+		}
+		if got != nil {
+			t.Fatalf("clampToRange = %+v, want nil (ecosystem can't enumerate versions)", got)
+		}
+	})
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+)
+
+// worktreePool hands out ephemeral copies of the tree being updated, so that
+// Runner.try can evaluate the two halves of a bisect split concurrently
+// without them trampling each other's manifest/install state. Copies are
+// created lazily, up to `size` of them, and are reused once returned.
+//
+// For a git repository, copies are `git worktree add` checkouts under a
+// tempdir; for a plain directory they fall back to `cp -a`.
+type worktreePool struct {
+	rootDir string
+	isGit   bool
+	tempDir string
+	size    int
+
+	mu    sync.Mutex
+	cond  *sync.Cond
+	free  []string
+	total int
+}
+
+func newWorktreePool(rootDir string, size int) (*worktreePool, error) {
+	tempDir, err := ioutil.TempDir("", "jsupdate-pool-")
+	if err != nil {
+		return nil, err
+	}
+	p := &worktreePool{
+		rootDir: rootDir,
+		isGit:   isGitRepo(rootDir),
+		tempDir: tempDir,
+		size:    size,
+	}
+	p.cond = sync.NewCond(&p.mu)
+	return p, nil
+}
+
+func isGitRepo(rootDir string) bool {
+	cmd := exec.Command("git", "rev-parse", "--is-inside-work-tree")
+	cmd.Dir = rootDir
+	return cmd.Run() == nil
+}
+
+// acquire blocks until a prepared working copy is available, creating one
+// (up to `size` total) if the pool hasn't reached capacity yet.
+func (p *worktreePool) acquire() (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for len(p.free) == 0 && p.total >= p.size {
+		p.cond.Wait()
+	}
+	if len(p.free) > 0 {
+		dir := p.free[len(p.free)-1]
+		p.free = p.free[:len(p.free)-1]
+		return dir, nil
+	}
+
+	p.total++
+	dir := filepath.Join(p.tempDir, fmt.Sprintf("tree-%d", p.total))
+	if p.isGit {
+		cmd := exec.Command("git", "worktree", "add", "--detach", "--quiet", dir)
+		cmd.Dir = p.rootDir
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			p.total--
+			return "", fmt.Errorf("git worktree add: %v", err)
+		}
+	} else {
+		cmd := exec.Command("cp", "-a", p.rootDir, dir)
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			p.total--
+			return "", fmt.Errorf("cp -a %s: %v", p.rootDir, err)
+		}
+	}
+	return dir, nil
+}
+
+// release returns a working copy to the pool for reuse by a later caller.
+func (p *worktreePool) release(dir string) {
+	p.mu.Lock()
+	p.free = append(p.free, dir)
+	p.cond.Signal()
+	p.mu.Unlock()
+}
+
+// yarnCacheDir returns a private YARN_CACHE_FOLDER for `dir`, so that
+// concurrent `yarn install` runs don't share (and corrupt) a single cache.
+func (p *worktreePool) yarnCacheDir(dir string) string {
+	return filepath.Join(p.tempDir, "yarn-cache-"+filepath.Base(dir))
+}
+
+// close removes the worktrees/copies handed out by the pool along with its
+// backing temp directory.
+func (p *worktreePool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.isGit {
+		for _, dir := range p.free {
+			cmd := exec.Command("git", "worktree", "remove", "--force", dir)
+			cmd.Dir = p.rootDir
+			_ = cmd.Run()
+		}
+	}
+	_ = os.RemoveAll(p.tempDir)
+}
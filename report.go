@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// Report is the structured summary of an update run, written to -report for
+// CI consumption.
+type Report struct {
+	Updates      []ReportUpdate `json:"updates"`
+	ManifestDiff string         `json:"manifestDiff,omitempty"`
+}
+
+// ReportUpdate describes the outcome of considering a single candidate
+// update.
+type ReportUpdate struct {
+	Package  string          `json:"package"`
+	Current  string          `json:"current"`
+	Latest   string          `json:"latest"`
+	Chosen   string          `json:"chosen,omitempty"`
+	Outcome  string          `json:"outcome"` // "upgraded", "failed", "skipped-pinned"
+	Attempts []ReportAttempt `json:"attempts,omitempty"`
+}
+
+// ReportAttempt records one evaluation of the test command made while
+// bisecting an update. Because r.test retries internally on failure (see
+// -retries), a single attempt here may itself represent several retries.
+type ReportAttempt struct {
+	Command    string `json:"command"`
+	Passed     bool   `json:"passed"`
+	DurationMS int64  `json:"durationMs"`
+}
+
+const (
+	outcomeUpgraded      = "upgraded"
+	outcomeFailed        = "failed"
+	outcomeSkippedPinned = "skipped-pinned"
+)
+
+// reportRecorder accumulates test attempts, keyed by package, as they
+// happen across a (possibly parallel) bisection run.
+type reportRecorder struct {
+	mu       sync.Mutex
+	attempts map[string][]ReportAttempt
+}
+
+func newReportRecorder() *reportRecorder {
+	return &reportRecorder{attempts: map[string][]ReportAttempt{}}
+}
+
+func (rr *reportRecorder) record(pkgs []string, attempt ReportAttempt) {
+	if rr == nil {
+		return
+	}
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	for _, p := range pkgs {
+		rr.attempts[p] = append(rr.attempts[p], attempt)
+	}
+}
+
+func (rr *reportRecorder) attemptsFor(pkg string) []ReportAttempt {
+	if rr == nil {
+		return nil
+	}
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	return rr.attempts[pkg]
+}
+
+// instrumentedTest wraps r.test so each call is timed and, if r.report is
+// set, recorded against pkgs.
+func (r Runner) instrumentedTest(pkgs []string) func() (bool, error) {
+	return func() (bool, error) {
+		start := time.Now()
+		ok, err := r.test()
+		if r.report != nil {
+			r.report.record(pkgs, ReportAttempt{
+				Command:    r.TestCommand,
+				Passed:     ok,
+				DurationMS: time.Since(start).Milliseconds(),
+			})
+		}
+		return ok, err
+	}
+}
+
+// packageNames returns the Package field of each update, for attributing a
+// test run to a report entry.
+func packageNames(updates []Update) []string {
+	names := make([]string, len(updates))
+	for i, u := range updates {
+		names[i] = u.Package
+	}
+	return names
+}
+
+// buildReport assembles the final report from the candidate updates that
+// were considered and the subset of them that were kept. manifestDiff is
+// the working tree diff captured by Run before it commits the update, since
+// by the time this runs (in Run's deferred report writer) the tree may
+// already be clean.
+func (r Runner) buildReport(candidates, goodUpdates []Update, manifestDiff string) Report {
+	report := Report{ManifestDiff: manifestDiff}
+	for _, u := range candidates {
+		ru := ReportUpdate{
+			Package: u.Package,
+			Current: u.Current,
+			Latest:  u.Latest,
+		}
+		switch {
+		case inUpdates(goodUpdates, u.Package):
+			ru.Outcome = outcomeUpgraded
+			// u.Latest is the pre-policy candidate value; a policy
+			// allowed-range may have clamped the version actually pinned,
+			// so report the kept update's effective version (the one
+			// chosenVersion would write to the manifest), not the original.
+			if kept := findUpdate(goodUpdates, u.Package); kept != nil {
+				ru.Latest = kept.Latest
+				ru.Chosen = chosenVersion(*kept)
+			}
+		case r.isIgnored(u.Package):
+			ru.Outcome = outcomeSkippedPinned
+		default:
+			ru.Outcome = outcomeFailed
+		}
+		ru.Attempts = r.report.attemptsFor(u.Package)
+		report.Updates = append(report.Updates, ru)
+	}
+
+	return report
+}
+
+func findUpdate(updates []Update, pkg string) *Update {
+	for i := range updates {
+		if updates[i].Package == pkg {
+			return &updates[i]
+		}
+	}
+	return nil
+}
+
+// gitDiff returns the working tree's current uncommitted diff, used to
+// report the final manifest changes.
+func gitDiff(rootDir string) (string, error) {
+	cmd := exec.Command("git", "-C", rootDir, "diff")
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// writeReport writes report to path in the requested format ("json", the
+// default, or "junit").
+func writeReport(path, format string, report Report) error {
+	switch format {
+	case "", "json":
+		buf, err := json.MarshalIndent(report, "", "\t")
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(path, buf, 0644)
+	case "junit":
+		buf, err := reportToJUnit(report)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(path, buf, 0644)
+	default:
+		return fmt.Errorf("unknown -report-format %q", format)
+	}
+}
+
+// junitTestSuite and junitTestCase hold just enough of the JUnit XML schema
+// for CI systems (GitLab, Jenkins) to surface each update as a test result.
+type junitTestSuite struct {
+	XMLName   xml.Name        `xml:"testsuite"`
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name    string        `xml:"name,attr"`
+	Failure *junitFailure `xml:"failure,omitempty"`
+	Skipped *junitSkipped `xml:"skipped,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+func reportToJUnit(report Report) ([]byte, error) {
+	suite := junitTestSuite{Name: "jsupdate"}
+	for _, u := range report.Updates {
+		tc := junitTestCase{Name: fmt.Sprintf("%s %s -> %s", u.Package, u.Current, u.Latest)}
+		switch u.Outcome {
+		case outcomeFailed:
+			tc.Failure = &junitFailure{Message: "update failed bisection"}
+			suite.Failures++
+		case outcomeSkippedPinned:
+			tc.Skipped = &junitSkipped{Message: "package is pinned/ignored"}
+		}
+		suite.TestCases = append(suite.TestCases, tc)
+		suite.Tests++
+	}
+
+	buf, err := xml.MarshalIndent(suite, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), buf...), nil
+}
+
+// isIgnored reports whether pkg was excluded from updates by r.policy, via
+// applyPolicy (ignore list, security-only, or an allowed-range with nothing
+// left to upgrade to).
+func (r Runner) isIgnored(pkg string) bool {
+	return r.policySkipped[pkg]
+}